@@ -1,12 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/russellcardullo/go-pingdom/pingdom"
+	yaml "gopkg.in/yaml.v2"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -20,39 +35,158 @@ var (
 	pingdomUp = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "up"),
 		"Whether the last pingdom scrape was successfull (1: up, 0: down)",
-		nil, nil,
+		[]string{"account"}, nil,
 	)
 	pingdomCheckStatus = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "check_status"),
 		"The current status of the check (1: true, 0: false)",
-		[]string{"id", "name", "hostname", "status"}, nil,
+		[]string{"id", "name", "hostname", "status", "type", "account"}, nil,
 	)
 	pingdomCheckResponseTime = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "check_response_time"),
 		"The response time of the last test in milliseconds",
-		[]string{"id", "name", "hostname"}, nil,
+		[]string{"id", "name", "hostname", "account"}, nil,
 	)
 	pingdomCheckResolution = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "check_resolution"),
 		"The resolution of the check",
-		[]string{"id", "name", "hostname"}, nil,
+		[]string{"id", "name", "hostname", "account"}, nil,
+	)
+	pingdomScrapeCollectorDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape_collector", "duration_seconds"),
+		"Time it took to list checks for an account",
+		[]string{"account"}, nil,
+	)
+	pingdomScrapeCollectorSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape_collector", "success"),
+		"Whether listing checks for an account succeeded (1: true, 0: false)",
+		[]string{"account"}, nil,
+	)
+
+	// pingdomAPIRequestDuration and pingdomAPIRequestsTotal are self-telemetry
+	// about the exporter's calls to the Pingdom API, not Pingdom data itself
+	pingdomAPIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken by calls to the Pingdom API",
+	}, []string{"account"})
+	pingdomAPIRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "api",
+		Name:      "requests_total",
+		Help:      "Total number of calls made to the Pingdom API",
+	}, []string{"account", "status"})
+
+	pingdomCheckLastErrorTime = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "check", "last_error_time_seconds"),
+		"Unix timestamp of the check's most recent failing result within the outage window (0: none)",
+		[]string{"id", "name", "account"}, nil,
+	)
+	pingdomCheckOutageSecondsTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "check", "outage_seconds_total"),
+		"Cumulative seconds the check was down over the configured outage window",
+		[]string{"id", "name", "account"}, nil,
+	)
+	pingdomCheckLastTestTime = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "check", "last_test_time_seconds"),
+		"Unix timestamp of the check's most recent test result",
+		[]string{"id", "name", "account"}, nil,
+	)
+	pingdomCheckProbeResponseTime = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "check", "probe_response_time_seconds"),
+		"Response time of the most recent test result, by probe ID",
+		[]string{"id", "name", "account", "probe"}, nil,
+	)
+	pingdomScrapeCacheHit = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape_cache", "hit"),
+		"Whether the check's summary data was served from cache (1: true, 0: false)",
+		[]string{"id", "name", "account"}, nil,
+	)
+	pingdomCheckPaused = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "check", "paused"),
+		"Whether the check is currently paused (1: true, 0: false)",
+		[]string{"id", "name", "account"}, nil,
+	)
+	pingdomCheckInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "check", "info"),
+		"Constant 1 metric carrying descriptive labels for a check",
+		[]string{"id", "name", "hostname", "type", "tags", "created", "account"}, nil,
 	)
 )
 
+// Account is a single named set of Pingdom credentials
+type Account struct {
+	Name     string `yaml:"name"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	APIKey   string `yaml:"api_key"`
+}
+
+// Config is the top-level YAML config file listing the accounts to scrape
+type Config struct {
+	Accounts []Account `yaml:"accounts"`
+}
+
+// loadConfig reads and parses a YAML accounts config file
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// clientsFromConfig builds a client per configured account, keyed by account name
+func clientsFromConfig(cfg *Config) map[string]*pingdom.Client {
+	clients := make(map[string]*pingdom.Client, len(cfg.Accounts))
+	for _, a := range cfg.Accounts {
+		clients[a.Name] = pingdom.NewClient(a.Username, a.Password, a.APIKey)
+	}
+	return clients
+}
+
+// checkSummary holds the derived outage/analysis data for a single check
+type checkSummary struct {
+	fetchedAt          time.Time
+	lastErrorTime      int64
+	lastTestTime       int64
+	outageSeconds      float64
+	probeResponseTimes map[string]float64
+}
+
+// ExporterOpts configures an Exporter
+type ExporterOpts struct {
+	Accounts     map[string]*pingdom.Client
+	OutageWindow time.Duration
+	CacheTTL     time.Duration
+
+	// Tags is passed through to Checks.List as the "tags" param, to ask the
+	// API to only return checks carrying these tags
+	Tags []string
+	// IncludeNameRegex and ExcludeNameRegex post-filter the checks returned
+	// by the API on check name. Either may be nil.
+	IncludeNameRegex *regexp.Regexp
+	ExcludeNameRegex *regexp.Regexp
+}
+
 // Exporter type
 type Exporter struct {
-	client *pingdom.Client
+	opts ExporterOpts
+
+	cacheMu sync.Mutex
+	cache   map[string]map[int]*checkSummary
 }
 
-// NewExporter returns a new exporter
-func NewExporter(username string, password string, apiKey string) (*Exporter, error) {
-	client := pingdom.NewClient(
-		username,
-		password,
-		apiKey,
-	)
+// NewExporter returns a new exporter that scrapes the accounts in opts
+func NewExporter(opts ExporterOpts) (*Exporter, error) {
 	return &Exporter{
-		client: client,
+		opts:  opts,
+		cache: make(map[string]map[int]*checkSummary),
 	}, nil
 }
 
@@ -62,25 +196,76 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- pingdomCheckStatus
 	ch <- pingdomCheckResponseTime
 	ch <- pingdomCheckResolution
+	ch <- pingdomScrapeCollectorDuration
+	ch <- pingdomScrapeCollectorSuccess
+	ch <- pingdomCheckLastErrorTime
+	ch <- pingdomCheckOutageSecondsTotal
+	ch <- pingdomCheckLastTestTime
+	ch <- pingdomCheckProbeResponseTime
+	ch <- pingdomScrapeCacheHit
+	ch <- pingdomCheckPaused
+	ch <- pingdomCheckInfo
 }
 
-// Collect metrics from the pingdom API
+// Collect metrics from the pingdom API, fanning out across all accounts
+// held by the exporter concurrently
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+
+	for name, client := range e.opts.Accounts {
+		wg.Add(1)
+		go func(account string, client *pingdom.Client) {
+			defer wg.Done()
+			e.collectAccount(ch, account, client)
+		}(name, client)
+	}
+
+	wg.Wait()
+}
+
+// collectAccount lists the checks for a single account and emits its metrics
+func (e *Exporter) collectAccount(ch chan<- prometheus.Metric, account string, client *pingdom.Client) {
+	listParams := map[string]string{}
+	if len(e.opts.Tags) > 0 {
+		listParams["tags"] = strings.Join(e.opts.Tags, ",")
+	}
+
+	start := time.Now()
+	checks, err := client.Checks.List(listParams)
+	duration := time.Since(start).Seconds()
+	observeAPICall(account, duration, err)
+
+	ch <- prometheus.MustNewConstMetric(
+		pingdomScrapeCollectorDuration, prometheus.GaugeValue, duration, account,
+	)
 
-	checks, err := e.client.Checks.List()
 	if err != nil {
-		log.Errorln("Error retrieving checks", err)
+		log.Errorln("Error retrieving checks for account", account, err)
 		ch <- prometheus.MustNewConstMetric(
-			pingdomUp, prometheus.GaugeValue, 0,
+			pingdomScrapeCollectorSuccess, prometheus.GaugeValue, 0, account,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			pingdomUp, prometheus.GaugeValue, 0, account,
 		)
 		return
 	}
 	ch <- prometheus.MustNewConstMetric(
-		pingdomUp, prometheus.GaugeValue, 1,
+		pingdomScrapeCollectorSuccess, prometheus.GaugeValue, 1, account,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		pingdomUp, prometheus.GaugeValue, 1, account,
 	)
 
 	for _, check := range checks {
+		if e.opts.IncludeNameRegex != nil && !e.opts.IncludeNameRegex.MatchString(check.Name) {
+			continue
+		}
+		if e.opts.ExcludeNameRegex != nil && e.opts.ExcludeNameRegex.MatchString(check.Name) {
+			continue
+		}
+
 		id := strconv.Itoa(check.ID)
+		checkType := check.Type.Name
 
 		unknown := float64(0)
 		paused := float64(0)
@@ -103,46 +288,199 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 
 		// pingdomCheckStatus
 		ch <- prometheus.MustNewConstMetric(
-			pingdomCheckStatus, prometheus.GaugeValue, unknown, id, check.Name, check.Hostname, "unknown",
+			pingdomCheckStatus, prometheus.GaugeValue, unknown, id, check.Name, check.Hostname, "unknown", checkType, account,
 		)
 		ch <- prometheus.MustNewConstMetric(
-			pingdomCheckStatus, prometheus.GaugeValue, paused, id, check.Name, check.Hostname, "paused",
+			pingdomCheckStatus, prometheus.GaugeValue, paused, id, check.Name, check.Hostname, "paused", checkType, account,
 		)
 		ch <- prometheus.MustNewConstMetric(
-			pingdomCheckStatus, prometheus.GaugeValue, up, id, check.Name, check.Hostname, "up",
+			pingdomCheckStatus, prometheus.GaugeValue, up, id, check.Name, check.Hostname, "up", checkType, account,
 		)
 		ch <- prometheus.MustNewConstMetric(
-			pingdomCheckStatus, prometheus.GaugeValue, unconfirmedDown, id, check.Name, check.Hostname, "unconfirmed_down",
+			pingdomCheckStatus, prometheus.GaugeValue, unconfirmedDown, id, check.Name, check.Hostname, "unconfirmed_down", checkType, account,
 		)
 		ch <- prometheus.MustNewConstMetric(
-			pingdomCheckStatus, prometheus.GaugeValue, down, id, check.Name, check.Hostname, "down",
+			pingdomCheckStatus, prometheus.GaugeValue, down, id, check.Name, check.Hostname, "down", checkType, account,
+		)
+
+		// pingdomCheckPaused
+		ch <- prometheus.MustNewConstMetric(
+			pingdomCheckPaused, prometheus.GaugeValue, paused, id, check.Name, account,
+		)
+
+		// pingdomCheckInfo
+		ch <- prometheus.MustNewConstMetric(
+			pingdomCheckInfo, prometheus.GaugeValue, 1,
+			id, check.Name, check.Hostname, checkType, tagsLabel(check.Tags), strconv.FormatInt(check.Created, 10), account,
 		)
 
 		// pingdomCheckResponseTime
 		ch <- prometheus.MustNewConstMetric(
-			pingdomCheckResponseTime, prometheus.GaugeValue, float64(check.LastResponseTime), id, check.Name, check.Hostname,
+			pingdomCheckResponseTime, prometheus.GaugeValue, float64(check.LastResponseTime), id, check.Name, check.Hostname, account,
 		)
 
 		// pingdomCheckResolution
 		ch <- prometheus.MustNewConstMetric(
-			pingdomCheckResolution, prometheus.GaugeValue, float64(check.Resolution), id, check.Name, check.Hostname,
+			pingdomCheckResolution, prometheus.GaugeValue, float64(check.Resolution), id, check.Name, check.Hostname, account,
+		)
+
+		summary, hit := e.summaryForCheck(account, client, check.ID, check.Resolution)
+		ch <- prometheus.MustNewConstMetric(
+			pingdomScrapeCacheHit, prometheus.GaugeValue, boolToFloat64(hit), id, check.Name, account,
+		)
+		if summary == nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			pingdomCheckLastErrorTime, prometheus.GaugeValue, float64(summary.lastErrorTime), id, check.Name, account,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			pingdomCheckLastTestTime, prometheus.GaugeValue, float64(summary.lastTestTime), id, check.Name, account,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			pingdomCheckOutageSecondsTotal, prometheus.GaugeValue, summary.outageSeconds, id, check.Name, account,
 		)
+		for probe, responseTime := range summary.probeResponseTimes {
+			ch <- prometheus.MustNewConstMetric(
+				pingdomCheckProbeResponseTime, prometheus.GaugeValue, responseTime, id, check.Name, account, probe,
+			)
+		}
+	}
+}
+
+// summaryForCheck returns the outage/analysis summary for a check, serving it
+// from cache when it's younger than the exporter's cacheTTL to avoid
+// hammering the Pingdom API on every scrape. The second return value reports
+// whether the cached value was used.
+func (e *Exporter) summaryForCheck(account string, client *pingdom.Client, checkID, resolution int) (*checkSummary, bool) {
+	e.cacheMu.Lock()
+	accountCache, ok := e.cache[account]
+	if !ok {
+		accountCache = make(map[int]*checkSummary)
+		e.cache[account] = accountCache
 	}
+	cached, ok := accountCache[checkID]
+	e.cacheMu.Unlock()
 
+	if ok && time.Since(cached.fetchedAt) < e.opts.CacheTTL {
+		return cached, true
+	}
+
+	summary, err := fetchCheckSummary(client, account, checkID, resolution, e.opts.OutageWindow)
+	if err != nil {
+		log.Errorln("Error retrieving summary for check", checkID, "on account", account, err)
+		return cached, ok
+	}
+
+	e.cacheMu.Lock()
+	e.cache[account][checkID] = summary
+	e.cacheMu.Unlock()
+
+	return summary, false
+}
+
+// fetchCheckSummary derives an outage/analysis summary for a single check
+// from its raw results, since go-pingdom doesn't wrap a dedicated outage
+// summary endpoint. It issues two Checks.Results calls: one for the most
+// recent result (last test time, per-probe response time) and one spanning
+// the outage window (to sum up downtime and find the last failing result).
+func fetchCheckSummary(client *pingdom.Client, account string, checkID, resolution int, outageWindow time.Duration) (*checkSummary, error) {
+	now := time.Now()
+
+	start := time.Now()
+	latest, err := client.Checks.Results(checkID, map[string]string{"limit": "1"})
+	observeAPICall(account, time.Since(start).Seconds(), err)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &checkSummary{
+		fetchedAt:          now,
+		probeResponseTimes: make(map[string]float64),
+	}
+
+	for _, result := range latest.Results {
+		summary.lastTestTime = int64(result.Time)
+		// Pingdom reports response times in milliseconds
+		summary.probeResponseTimes[strconv.Itoa(result.ProbeID)] = float64(result.ResponseTime) / 1000
+		if result.Status == "down" {
+			summary.lastErrorTime = int64(result.Time)
+		}
+		break
+	}
+
+	start = time.Now()
+	window, err := client.Checks.Results(checkID, map[string]string{
+		"from": strconv.FormatInt(now.Add(-outageWindow).Unix(), 10),
+		"to":   strconv.FormatInt(now.Unix(), 10),
+	})
+	observeAPICall(account, time.Since(start).Seconds(), err)
+	if err != nil {
+		return nil, err
+	}
+
+	// go-pingdom's Results call returns one entry per check run, not a
+	// contiguous outage interval, so approximate downtime as the number of
+	// "down" results times the check's resolution (its test interval, in
+	// minutes).
+	var downResults int
+	for _, result := range window.Results {
+		if result.Status == "down" {
+			downResults++
+			if int64(result.Time) > summary.lastErrorTime {
+				summary.lastErrorTime = int64(result.Time)
+			}
+		}
+	}
+	summary.outageSeconds = float64(downResults * resolution * 60)
+
+	return summary, nil
+}
+
+// observeAPICall records self-telemetry for a single call to the Pingdom API
+func observeAPICall(account string, duration float64, err error) {
+	pingdomAPIRequestDuration.WithLabelValues(account).Observe(duration)
+	if err != nil {
+		pingdomAPIRequestsTotal.WithLabelValues(account, "error").Inc()
+	} else {
+		pingdomAPIRequestsTotal.WithLabelValues(account, "success").Inc()
+	}
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-func init() {
-	prometheus.MustRegister(version.NewCollector(namespace + "_exporter"))
+// tagsLabel joins a check's tags into a single comma-separated label value
+func tagsLabel(tags []pingdom.CheckResponseTag) string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ",")
 }
 
 func main() {
 	var (
-		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":8000").String()
-		metricsPath   = kingpin.Flag("web.metrics-path", "Path under which to expose metrics").Default("/metrics").String()
-		server        = kingpin.Command("server", "")
-		username      = server.Arg("pingdom.username", "Username for the Pingdom account").Required().String()
-		password      = server.Arg("pingdom.password", "Password for the Pingdom account").Required().String()
-		apiKey        = server.Arg("pingdom.key", "API key").Required().String()
+		listenAddress    = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":8000").String()
+		metricsPath      = kingpin.Flag("web.metrics-path", "Path under which to expose Pingdom metrics").Default("/metrics").String()
+		telemetryPath    = kingpin.Flag("web.telemetry-path", "Path under which to expose the exporter's own metrics").Default("/exporter-metrics").String()
+		probePath        = kingpin.Flag("web.probe-path", "Path under which to expose the per-account probe endpoint").Default("/probe").String()
+		configFile       = kingpin.Flag("config.file", "Path to a YAML file listing the Pingdom accounts to scrape").String()
+		outageWindow     = kingpin.Flag("pingdom.outage-window", "How far back to look when summing up outage seconds for a check").Default("24h").Duration()
+		cacheTTL         = kingpin.Flag("pingdom.cache-ttl", "How long to cache a check's outage/analysis summary for").Default("5m").Duration()
+		tags             = kingpin.Flag("pingdom.tags", "Only scrape checks carrying this tag (repeatable)").Strings()
+		includeNameRegex = kingpin.Flag("pingdom.include-name-regex", "Only scrape checks whose name matches this regex").String()
+		excludeNameRegex = kingpin.Flag("pingdom.exclude-name-regex", "Never scrape checks whose name matches this regex").String()
+		tlsCertFile      = kingpin.Flag("web.tls-cert-file", "Path to a TLS certificate file to serve HTTPS").String()
+		tlsKeyFile       = kingpin.Flag("web.tls-key-file", "Path to the TLS certificate's private key").String()
+		tlsClientCA      = kingpin.Flag("web.tls-client-ca", "Path to a CA bundle to verify client certificates against, for mTLS").String()
+		authUser         = kingpin.Flag("web.auth-user", "Username required to access the web interface, via HTTP basic auth").Envar("PINGDOM_EXPORTER_WEB_AUTH_USER").String()
+		authPass         = kingpin.Flag("web.auth-pass", "Password required to access the web interface, via HTTP basic auth").Envar("PINGDOM_EXPORTER_WEB_AUTH_PASS").String()
 	)
 
 	log.AddFlags(kingpin.CommandLine)
@@ -150,27 +488,177 @@ func main() {
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
 
-	exporter, err := NewExporter(*username, *password, *apiKey)
+	// Pingdom credentials are deliberately not kingpin flags/args: those
+	// show up in `ps`. They're only ever read from --config.file or from
+	// the environment.
+	var accounts map[string]*pingdom.Client
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatalln("Error loading config file", err)
+		}
+		accounts = clientsFromConfig(cfg)
+	} else {
+		accounts = map[string]*pingdom.Client{
+			"default": pingdom.NewClient(
+				os.Getenv("PINGDOM_USERNAME"),
+				os.Getenv("PINGDOM_PASSWORD"),
+				os.Getenv("PINGDOM_API_KEY"),
+			),
+		}
+	}
+
+	var includeRegex, excludeRegex *regexp.Regexp
+	if *includeNameRegex != "" {
+		var err error
+		includeRegex, err = regexp.Compile(*includeNameRegex)
+		if err != nil {
+			log.Fatalln("Error compiling --pingdom.include-name-regex", err)
+		}
+	}
+	if *excludeNameRegex != "" {
+		var err error
+		excludeRegex, err = regexp.Compile(*excludeNameRegex)
+		if err != nil {
+			log.Fatalln("Error compiling --pingdom.exclude-name-regex", err)
+		}
+	}
+
+	baseOpts := ExporterOpts{
+		OutageWindow:     *outageWindow,
+		CacheTTL:         *cacheTTL,
+		Tags:             *tags,
+		IncludeNameRegex: includeRegex,
+		ExcludeNameRegex: excludeRegex,
+	}
+
+	opts := baseOpts
+	opts.Accounts = accounts
+	exporter, err := NewExporter(opts)
 	if err != nil {
 		log.Fatalln("Error")
 	}
 
-	prometheus.MustRegister(exporter)
+	pingdomRegistry := prometheus.NewRegistry()
+	pingdomRegistry.MustRegister(exporter)
+
+	telemetryRegistry := prometheus.NewRegistry()
+	telemetryRegistry.MustRegister(version.NewCollector(namespace + "_exporter"))
+	telemetryRegistry.MustRegister(prometheus.NewGoCollector())
+	telemetryRegistry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	telemetryRegistry.MustRegister(pingdomAPIRequestDuration)
+	telemetryRegistry.MustRegister(pingdomAPIRequestsTotal)
 
 	log.Infoln("Starting "+namespace+"_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	http.Handle(*metricsPath, prometheus.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, promhttp.HandlerFor(pingdomRegistry, promhttp.HandlerOpts{}))
+	mux.Handle(*telemetryPath, promhttp.HandlerFor(telemetryRegistry, promhttp.HandlerOpts{}))
+	mux.HandleFunc(*probePath, func(w http.ResponseWriter, r *http.Request) {
+		probeHandler(w, r, accounts, baseOpts)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 						 <head><title>Pingdom Exporter</title></head>
 						 <body>
 						 <h1>Pingdom Exporter</h1>
 						 <p><a href='` + *metricsPath + `'>Metrics</a></p>
+						 <p><a href='` + *telemetryPath + `'>Exporter Metrics</a></p>
 						 </body>
 						 </html>`))
 	})
 
+	srv := &http.Server{
+		Addr:    *listenAddress,
+		Handler: basicAuthHandler(mux, *authUser, *authPass),
+	}
+
+	if *tlsClientCA != "" {
+		caCert, err := ioutil.ReadFile(*tlsClientCA)
+		if err != nil {
+			log.Fatalln("Error reading --web.tls-client-ca", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			log.Fatalln("Error parsing --web.tls-client-ca")
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  caPool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+
+		log.Infoln("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorln("Error shutting down server", err)
+		}
+		close(idleConnsClosed)
+	}()
+
 	log.Infoln("Listening on", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	var serveErr error
+	if *tlsCertFile != "" && *tlsKeyFile != "" {
+		serveErr = srv.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile)
+	} else {
+		serveErr = srv.ListenAndServe()
+	}
+	if serveErr != nil && serveErr != http.ErrServerClosed {
+		log.Fatalln(serveErr)
+	}
+
+	<-idleConnsClosed
+}
+
+// basicAuthHandler wraps h with HTTP basic auth when either user or pass is
+// set; when both are empty it returns h unchanged
+func basicAuthHandler(h http.Handler, user, pass string) http.Handler {
+	if user == "" && pass == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqUser, reqPass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(reqUser), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(reqPass), []byte(pass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+namespace+`_exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// probeHandler scopes a scrape to a single named account, as selected by the
+// "target" query parameter, using a fresh registry for the request
+func probeHandler(w http.ResponseWriter, r *http.Request, accounts map[string]*pingdom.Client, opts ExporterOpts) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := accounts[target]
+	if !ok {
+		http.Error(w, "unknown target "+url.QueryEscape(target), http.StatusNotFound)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	opts.Accounts = map[string]*pingdom.Client{target: client}
+	exporter, err := NewExporter(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	registry.MustRegister(exporter)
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 }